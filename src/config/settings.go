@@ -15,8 +15,10 @@ var (
 	PathQrCode      = "statics/qrcode"
 	PathSendItems   = "statics/senditems"
 	PathMedia       = "statics/media"
+	PathAvatars     = "statics/avatars"
 	PathStorages    = "storages"
 	PathChatStorage = "storages/chat.txt"
+	PathACL         = "storages/acl.json"
 
 	DBURI = "file:storages/whatsapp.db?_foreign_keys=off"
 
@@ -30,4 +32,17 @@ var (
 	WhatsappTypeUser                     = "@s.whatsapp.net"
 	WhatsappTypeGroup                    = "@g.us"
 	WhatsappAccountValidation            = true
+
+	WhatsappACL ACL
 )
+
+// ACL is the JID allow/block list consulted by pkg/whatsapp/acl. It is
+// seeded from storages/acl.json (or whatever sets WhatsappACL directly at
+// startup, e.g. an env var) and can be replaced at runtime through the
+// PUT /acl endpoint.
+type ACL struct {
+	AllowList           []string `json:"allow_list"`
+	BlockList           []string `json:"block_list"`
+	BlockGroups         bool     `json:"block_groups"`
+	AutoReplyExceptions []string `json:"auto_reply_exceptions"`
+}
@@ -0,0 +1,39 @@
+// Package error defines the typed errors shared by pkg/whatsapp and its
+// subpackages, so HTTP handlers and callers can surface a clear message
+// without string-matching generic errors.
+package error
+
+// appError is a simple error carrying a human-readable message.
+type appError struct {
+	message string
+}
+
+func (e *appError) Error() string {
+	return e.message
+}
+
+// Sentinel errors for conditions callers check against directly.
+var (
+	ErrInvalidJID   = &appError{message: "invalid JID"}
+	ErrNotConnected = &appError{message: "whatsapp is not connected, please reconnect"}
+	ErrNotLoggedIn  = &appError{message: "whatsapp is not logged in, please login first"}
+
+	// ErrJIDBlocked is returned when a JID is denied by the configured
+	// allow/block list. See pkg/whatsapp/acl.
+	ErrJIDBlocked = &appError{message: "JID is blocked by the configured allow/block list"}
+)
+
+// InvalidJID wraps a JID validation failure with caller-supplied detail.
+func InvalidJID(detail string) error {
+	return &appError{message: detail}
+}
+
+// InternalServerError wraps an unexpected failure meant for a 500 response.
+func InternalServerError(detail string) error {
+	return &appError{message: detail}
+}
+
+// WebhookError wraps a webhook delivery failure.
+func WebhookError(detail string) error {
+	return &appError{message: detail}
+}
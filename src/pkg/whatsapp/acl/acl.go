@@ -0,0 +1,104 @@
+// Package acl enforces a config-driven JID allow/block list, the same
+// shape several whatsmeow example bridges use to keep a numbers.json
+// blacklist out of the way of inbound/outbound traffic. block_list (and
+// block_groups, for every group JID) deny by default; allow_list always
+// wins over them. The list starts from config.WhatsappACL and can be
+// hot-reloaded at runtime through PUT /acl.
+package acl
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/aldinokemal/go-whatsapp-web-multidevice/config"
+	pkgError "github.com/aldinokemal/go-whatsapp-web-multidevice/pkg/error"
+	"go.mau.fi/whatsmeow/types"
+)
+
+var (
+	mu      sync.RWMutex
+	current config.ACL
+)
+
+// Init seeds the live ACL from config.WhatsappACL, then replaces it
+// wholesale with storages/acl.json's contents if that file exists (fields
+// missing from the file are left empty, not kept). It must be called
+// once during startup, after config is loaded.
+func Init() error {
+	mu.Lock()
+	current = config.WhatsappACL
+	mu.Unlock()
+
+	raw, err := os.ReadFile(config.PathACL)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return pkgError.InternalServerError(fmt.Sprintf("failed to read acl file: %v", err))
+	}
+
+	var list config.ACL
+	if err = json.Unmarshal(raw, &list); err != nil {
+		return pkgError.InternalServerError(fmt.Sprintf("failed to parse acl file: %v", err))
+	}
+
+	mu.Lock()
+	current = list
+	mu.Unlock()
+	return nil
+}
+
+// Current returns a copy of the live ACL.
+func Current() config.ACL {
+	mu.RLock()
+	defer mu.RUnlock()
+	return current
+}
+
+// Replace swaps in a new ACL and persists it to storages/acl.json so the
+// change survives a restart.
+func Replace(list config.ACL) error {
+	raw, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return pkgError.InternalServerError(fmt.Sprintf("failed to encode acl: %v", err))
+	}
+	if err = os.WriteFile(config.PathACL, raw, 0644); err != nil {
+		return pkgError.InternalServerError(fmt.Sprintf("failed to write acl file: %v", err))
+	}
+
+	mu.Lock()
+	current = list
+	mu.Unlock()
+	return nil
+}
+
+// IsAllowed reports whether jid may send/receive traffic under the
+// current ACL.
+func IsAllowed(jid types.JID) bool {
+	list := Current()
+
+	if contains(list.AllowList, jid.User) {
+		return true
+	}
+	if list.BlockGroups && jid.Server == types.GroupServer {
+		return false
+	}
+	return !contains(list.BlockList, jid.User)
+}
+
+// SuppressAutoReply reports whether jid is on auto_reply_exceptions and
+// should not receive config.WhatsappAutoReplyMessage.
+func SuppressAutoReply(jid types.JID) bool {
+	return contains(Current().AutoReplyExceptions, jid.User)
+}
+
+func contains(list []string, user string) bool {
+	for _, entry := range list {
+		if entry == user {
+			return true
+		}
+	}
+	return false
+}
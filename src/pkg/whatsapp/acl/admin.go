@@ -0,0 +1,44 @@
+package acl
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/aldinokemal/go-whatsapp-web-multidevice/config"
+)
+
+// RegisterRoutes wires the ACL admin endpoints onto mux:
+//
+//	GET /acl  read the live allow/block list
+//	PUT /acl  replace it and persist to storages/acl.json
+func RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/acl", aclHandler)
+}
+
+func aclHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(Current())
+	case http.MethodPut:
+		putACLHandler(w, r)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func putACLHandler(w http.ResponseWriter, r *http.Request) {
+	var list config.ACL
+	if err := json.NewDecoder(r.Body).Decode(&list); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := Replace(list); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(list)
+}
@@ -0,0 +1,242 @@
+package whatsapp
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aldinokemal/go-whatsapp-web-multidevice/config"
+	pkgError "github.com/aldinokemal/go-whatsapp-web-multidevice/pkg/error"
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+)
+
+// avatarHTTPClient fetches profile picture jpgs from WhatsApp's CDN, with
+// the same timeout the webhook queue uses for its outbound deliveries.
+var avatarHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// Contact is the resolved, display-ready view of a JID: the name fields
+// whatsmeow keeps in its contact store, plus whatever we know about its
+// cached avatar.
+type Contact struct {
+	JID          string `json:"jid"`
+	PushName     string `json:"push_name"`
+	FullName     string `json:"full_name"`
+	BusinessName string `json:"business_name"`
+	AvatarID     string `json:"avatar_id,omitempty"`
+}
+
+// refreshContacts reloads the whole contact cache from whatsmeow's contact
+// store. It is called on events.Connected, mirroring how matterbridge's
+// whatsmeow bridge bootstraps its contacts/users/userAvatars caches.
+func (s *Session) refreshContacts() {
+	all, err := s.Client.Store.Contacts.GetAllContacts()
+	if err != nil {
+		s.Log.Warnf("Failed to refresh contacts: %v", err)
+		return
+	}
+
+	s.contactsMu.Lock()
+	s.contacts = all
+	s.contactsMu.Unlock()
+}
+
+// refreshContact re-reads a single JID from the contact store, used to keep
+// the cache current as events.Contact updates trickle in.
+func (s *Session) refreshContact(jid types.JID) {
+	info, err := s.Client.Store.Contacts.GetContact(jid)
+	if err != nil {
+		s.Log.Warnf("Failed to refresh contact %s: %v", jid.String(), err)
+		return
+	}
+
+	s.contactsMu.Lock()
+	s.contacts[jid] = info
+	s.contactsMu.Unlock()
+}
+
+// ResolveContact builds the display-ready Contact for jid out of the
+// cache, falling back to the bare JID when nothing is known about it yet.
+func (s *Session) ResolveContact(jid types.JID) Contact {
+	s.contactsMu.RLock()
+	info, ok := s.contacts[jid]
+	s.contactsMu.RUnlock()
+
+	contact := Contact{JID: jid.String()}
+	if ok {
+		contact.PushName = info.PushName
+		contact.FullName = info.FullName
+		contact.BusinessName = info.BusinessName
+	}
+
+	s.avatarsMu.RLock()
+	if avatar := s.avatars[jid]; avatar != nil {
+		contact.AvatarID = avatar.ID
+	}
+	s.avatarsMu.RUnlock()
+
+	return contact
+}
+
+// avatarPath returns where jid's cached avatar jpg lives on disk.
+func avatarPath(jid types.JID) string {
+	sum := sha1.Sum([]byte(jid.String()))
+	return fmt.Sprintf("%s/%s.jpg", config.PathAvatars, hex.EncodeToString(sum[:]))
+}
+
+// fetchAvatar asks whatsmeow for jid's current ProfilePictureInfo on every
+// call, passing the cached ID (if any) as ExistingID. When whatsmeow reports
+// no change it returns the cached info without downloading; otherwise it
+// downloads the new picture and replaces the cached info.
+func (s *Session) fetchAvatar(jid types.JID) (*types.ProfilePictureInfo, error) {
+	// Serialize fetches so two concurrent requests for the same
+	// not-yet-cached avatar can't both download into the same file.
+	s.avatarFetchMu.Lock()
+	defer s.avatarFetchMu.Unlock()
+
+	s.avatarsMu.RLock()
+	cached := s.avatars[jid]
+	s.avatarsMu.RUnlock()
+
+	params := &whatsmeow.GetProfilePictureParams{}
+	if cached != nil {
+		params.ExistingID = cached.ID
+	}
+
+	info, err := s.Client.GetProfilePictureInfo(jid, params)
+	if err != nil {
+		return nil, pkgError.InternalServerError(fmt.Sprintf("failed to fetch avatar for %s: %v", jid.String(), err))
+	}
+	if info == nil {
+		return cached, nil
+	}
+
+	if err = s.downloadAvatar(jid, info); err != nil {
+		return nil, err
+	}
+
+	s.avatarsMu.Lock()
+	s.avatars[jid] = info
+	s.avatarsMu.Unlock()
+	return info, nil
+}
+
+// downloadAvatar saves info's profile picture to statics/avatars/<jid-hash>.jpg.
+func (s *Session) downloadAvatar(jid types.JID, info *types.ProfilePictureInfo) error {
+	if err := os.MkdirAll(config.PathAvatars, 0755); err != nil {
+		return pkgError.InternalServerError(fmt.Sprintf("failed to create avatars dir: %v", err))
+	}
+
+	resp, err := avatarHTTPClient.Get(info.URL)
+	if err != nil {
+		return pkgError.InternalServerError(fmt.Sprintf("failed to download avatar for %s: %v", jid.String(), err))
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return pkgError.InternalServerError(fmt.Sprintf("failed to download avatar for %s: unexpected status %s", jid.String(), resp.Status))
+	}
+
+	f, err := os.Create(avatarPath(jid))
+	if err != nil {
+		return pkgError.InternalServerError(fmt.Sprintf("failed to save avatar for %s: %v", jid.String(), err))
+	}
+	defer f.Close()
+
+	if _, err = io.Copy(f, resp.Body); err != nil {
+		return pkgError.InternalServerError(fmt.Sprintf("failed to write avatar for %s: %v", jid.String(), err))
+	}
+	return nil
+}
+
+// RegisterContactRoutes wires the contact endpoints onto mux, each scoped
+// to the session named by its ?session_id= query parameter:
+//
+//	GET /contacts?session_id=               list every cached contact
+//	GET /contacts/{jid}?session_id=          resolved push/full/business name
+//	GET /contacts/{jid}/avatar?session_id=   cached profile picture jpg
+func RegisterContactRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/contacts", listContactsHandler)
+	mux.HandleFunc("/contacts/", contactHandler)
+}
+
+func listContactsHandler(w http.ResponseWriter, r *http.Request) {
+	session, ok := sessionFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	session.contactsMu.RLock()
+	jids := make([]types.JID, 0, len(session.contacts))
+	for jid := range session.contacts {
+		jids = append(jids, jid)
+	}
+	session.contactsMu.RUnlock()
+
+	contacts := make([]Contact, 0, len(jids))
+	for _, jid := range jids {
+		contacts = append(contacts, session.ResolveContact(jid))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(contacts)
+}
+
+// contactHandler dispatches GET /contacts/{jid} and GET /contacts/{jid}/avatar.
+func contactHandler(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/contacts/")
+	wantsAvatar := strings.HasSuffix(path, "/avatar")
+	jidStr := strings.TrimSuffix(path, "/avatar")
+	if jidStr == "" {
+		http.Error(w, "jid is required", http.StatusBadRequest)
+		return
+	}
+
+	session, ok := sessionFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	jid, err := ParseJID(jidStr)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if wantsAvatar {
+		getAvatarHandler(w, r, session, jid)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(session.ResolveContact(jid))
+}
+
+// getAvatarHandler serves jid's cached profile picture, honoring
+// If-None-Match against the avatar's ProfilePictureInfo.ID so frontends
+// can poll cheaply.
+func getAvatarHandler(w http.ResponseWriter, r *http.Request, session *Session, jid types.JID) {
+	info, err := session.fetchAvatar(jid)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if info == nil {
+		http.Error(w, "no avatar found", http.StatusNotFound)
+		return
+	}
+
+	etag := `"` + info.ID + `"`
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	http.ServeFile(w, r, avatarPath(jid))
+}
@@ -0,0 +1,34 @@
+package whatsapp
+
+import (
+	"go.mau.fi/whatsmeow/types"
+)
+
+// refreshJoinedGroups re-fetches the list of joined groups from the server
+// and swaps it into this session's cache.
+func (s *Session) refreshJoinedGroups() {
+	groups, err := s.Client.GetJoinedGroups()
+	if err != nil {
+		s.Log.Warnf("Failed to refresh joined groups: %v", err)
+		return
+	}
+
+	jids := make([]types.JID, 0, len(groups))
+	for _, group := range groups {
+		jids = append(jids, group.JID)
+	}
+
+	s.joinedGroupsMu.Lock()
+	s.joinedGroups = jids
+	s.joinedGroupsMu.Unlock()
+}
+
+// JoinedGroups returns a snapshot of this session's joined-groups cache.
+func (s *Session) JoinedGroups() []types.JID {
+	s.joinedGroupsMu.RLock()
+	defer s.joinedGroupsMu.RUnlock()
+
+	out := make([]types.JID, len(s.joinedGroups))
+	copy(out, s.joinedGroups)
+	return out
+}
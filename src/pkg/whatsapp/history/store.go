@@ -0,0 +1,425 @@
+// Package history normalizes incoming events.HistorySync protobufs into
+// SQLite-backed chats/messages/participants tables so chat history can be
+// queried through a REST API instead of being dumped to JSON files on disk.
+package history
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	pkgError "github.com/aldinokemal/go-whatsapp-web-multidevice/pkg/error"
+	_ "github.com/mattn/go-sqlite3"
+	"go.mau.fi/whatsmeow/proto/waHistorySync"
+	"go.mau.fi/whatsmeow/proto/waWeb"
+)
+
+// Chat is a normalized row of the chats table.
+type Chat struct {
+	SessionID       string `json:"session_id"`
+	JID             string `json:"jid"`
+	Name            string `json:"name"`
+	IsGroup         bool   `json:"is_group"`
+	LastMessageTime int64  `json:"last_message_time"`
+}
+
+// Message is a normalized row of the messages table.
+type Message struct {
+	ID        string `json:"id"`
+	SessionID string `json:"session_id"`
+	ChatJID   string `json:"chat_jid"`
+	SenderJID string `json:"sender_jid"`
+	FromMe    bool   `json:"from_me"`
+	Text      string `json:"text"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// MediaReference is a normalized row of the media_references table: a
+// pointer to the media attachment carried by one message, without the
+// attachment bytes themselves (history sync doesn't include those).
+type MediaReference struct {
+	SessionID string `json:"session_id"`
+	ChatJID   string `json:"chat_jid"`
+	MessageID string `json:"message_id"`
+	MediaType string `json:"media_type"`
+	MimeType  string `json:"mime_type"`
+}
+
+// Cursor is the opaque pagination token returned to REST clients, encoded
+// as base64 JSON so it stays stable across process restarts.
+type Cursor struct {
+	MessageID string `json:"msg_id"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// EncodeCursor renders a Cursor as the opaque string handed back to clients.
+func EncodeCursor(c Cursor) string {
+	raw, _ := json.Marshal(c)
+	return base64.URLEncoding.EncodeToString(raw)
+}
+
+// DecodeCursor parses a cursor string produced by EncodeCursor.
+func DecodeCursor(s string) (Cursor, error) {
+	var c Cursor
+	if s == "" {
+		return c, nil
+	}
+	raw, err := base64.URLEncoding.DecodeString(s)
+	if err != nil {
+		return c, pkgError.InternalServerError(fmt.Sprintf("invalid history cursor: %v", err))
+	}
+	if err = json.Unmarshal(raw, &c); err != nil {
+		return c, pkgError.InternalServerError(fmt.Sprintf("invalid history cursor: %v", err))
+	}
+	return c, nil
+}
+
+var db *sql.DB
+
+// Init opens the history database and creates its schema if needed. It
+// must be called once during startup, after config is loaded.
+func Init(dbPath string) error {
+	var err error
+	db, err = sql.Open("sqlite3", fmt.Sprintf("file:%s?_foreign_keys=off", dbPath))
+	if err != nil {
+		return pkgError.InternalServerError(fmt.Sprintf("failed to open history db: %v", err))
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS chats (
+			session_id        TEXT NOT NULL,
+			jid               TEXT NOT NULL,
+			name              TEXT NOT NULL DEFAULT '',
+			is_group          BOOLEAN NOT NULL DEFAULT 0,
+			last_message_time INTEGER NOT NULL DEFAULT 0,
+			PRIMARY KEY (session_id, jid)
+		);
+		CREATE TABLE IF NOT EXISTS messages (
+			id         TEXT NOT NULL,
+			session_id TEXT NOT NULL,
+			chat_jid   TEXT NOT NULL,
+			sender_jid TEXT NOT NULL,
+			from_me    BOOLEAN NOT NULL DEFAULT 0,
+			text       TEXT NOT NULL DEFAULT '',
+			timestamp  INTEGER NOT NULL,
+			PRIMARY KEY (session_id, chat_jid, id)
+		);
+		CREATE INDEX IF NOT EXISTS messages_chat_timestamp_idx ON messages (session_id, chat_jid, timestamp DESC);
+		CREATE TABLE IF NOT EXISTS participants (
+			session_id TEXT NOT NULL,
+			chat_jid   TEXT NOT NULL,
+			jid        TEXT NOT NULL,
+			PRIMARY KEY (session_id, chat_jid, jid)
+		);
+		CREATE TABLE IF NOT EXISTS media_references (
+			session_id TEXT NOT NULL,
+			chat_jid   TEXT NOT NULL,
+			message_id TEXT NOT NULL,
+			media_type TEXT NOT NULL,
+			mime_type  TEXT NOT NULL DEFAULT '',
+			PRIMARY KEY (session_id, chat_jid, message_id)
+		);
+	`)
+	if err != nil {
+		return pkgError.InternalServerError(fmt.Sprintf("failed to create history schema: %v", err))
+	}
+	return nil
+}
+
+// Ingest normalizes one HistorySync payload into the chats/messages/
+// participants/media_references tables. InitialBootstrap and Recent are
+// indexed as-is; Full syncs are handled the same way here since, unlike
+// the bootstrap step, ordering across chats no longer matters once the
+// backfill request that triggered them has already prioritized groups
+// over 1:1 chats. It returns the total number of messages ingested along
+// with a per-chat breakdown, so callers waiting on a specific chat (e.g.
+// an on-demand backfill request) can tell whether this sync actually
+// touched it.
+func Ingest(sessionID string, data *waHistorySync.HistorySync) (int, map[string]int, error) {
+	if data == nil {
+		return 0, nil, nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, nil, pkgError.InternalServerError(fmt.Sprintf("failed to begin history tx: %v", err))
+	}
+	defer tx.Rollback()
+
+	ingested := 0
+	perChat := make(map[string]int)
+	conversations := sortGroupsFirst(data.GetConversations())
+	for _, conv := range conversations {
+		chatJID := conv.GetID()
+		if chatJID == "" {
+			continue
+		}
+
+		isGroup := isGroupJID(chatJID)
+		if _, err = tx.Exec(
+			`INSERT INTO chats (session_id, jid, name, is_group, last_message_time) VALUES (?, ?, ?, ?, 0)
+			 ON CONFLICT(session_id, jid) DO UPDATE SET name = excluded.name, is_group = excluded.is_group`,
+			sessionID, chatJID, conv.GetName(), isGroup,
+		); err != nil {
+			return ingested, perChat, pkgError.InternalServerError(fmt.Sprintf("failed to upsert chat %s: %v", chatJID, err))
+		}
+
+		// Record the chat as touched by this sync even if it carries no
+		// new messages, so a waiter blocked on this exact chat still
+		// gets woken (with a count of 0) instead of timing out.
+		perChat[chatJID] = 0
+
+		participants := make(map[string]bool)
+		var lastTimestamp int64
+		for _, hsm := range conv.GetMessages() {
+			wmi := hsm.GetMessage()
+			if wmi == nil {
+				continue
+			}
+
+			id := wmi.GetKey().GetID()
+			timestamp := int64(wmi.GetMessageTimestamp())
+			participantJID := wmi.GetKey().GetParticipant()
+			senderJID := participantJID
+			if senderJID == "" {
+				senderJID = chatJID
+			}
+
+			res, err := tx.Exec(
+				`INSERT INTO messages (id, session_id, chat_jid, sender_jid, from_me, text, timestamp) VALUES (?, ?, ?, ?, ?, ?, ?)
+				 ON CONFLICT(session_id, chat_jid, id) DO NOTHING`,
+				id, sessionID, chatJID, senderJID, wmi.GetKey().GetFromMe(), messageText(wmi), timestamp,
+			)
+			if err != nil {
+				return ingested, perChat, pkgError.InternalServerError(fmt.Sprintf("failed to insert message %s: %v", id, err))
+			}
+			// DO NOTHING means this message was already stored by an
+			// earlier sync; don't count it as newly ingested.
+			if affected, _ := res.RowsAffected(); affected == 0 {
+				if timestamp > lastTimestamp {
+					lastTimestamp = timestamp
+				}
+				continue
+			}
+
+			// Key.Participant is only set for messages sent by other
+			// group members; an empty value here means "me", not "the
+			// group itself", so it must not be recorded as a participant.
+			if isGroup && participantJID != "" {
+				participants[participantJID] = true
+			}
+
+			if mediaType, mimeType, ok := mediaReference(wmi); ok {
+				if _, err = tx.Exec(
+					`INSERT INTO media_references (session_id, chat_jid, message_id, media_type, mime_type) VALUES (?, ?, ?, ?, ?)
+					 ON CONFLICT(session_id, chat_jid, message_id) DO UPDATE SET media_type = excluded.media_type, mime_type = excluded.mime_type`,
+					sessionID, chatJID, id, mediaType, mimeType,
+				); err != nil {
+					return ingested, perChat, pkgError.InternalServerError(fmt.Sprintf("failed to insert media reference for %s: %v", id, err))
+				}
+			}
+
+			ingested++
+			perChat[chatJID]++
+			if timestamp > lastTimestamp {
+				lastTimestamp = timestamp
+			}
+		}
+
+		for participantJID := range participants {
+			if _, err = tx.Exec(
+				`INSERT INTO participants (session_id, chat_jid, jid) VALUES (?, ?, ?) ON CONFLICT(session_id, chat_jid, jid) DO NOTHING`,
+				sessionID, chatJID, participantJID,
+			); err != nil {
+				return ingested, perChat, pkgError.InternalServerError(fmt.Sprintf("failed to insert participant %s for %s: %v", participantJID, chatJID, err))
+			}
+		}
+
+		if lastTimestamp > 0 {
+			if _, err = tx.Exec(`UPDATE chats SET last_message_time = ? WHERE session_id = ? AND jid = ? AND last_message_time < ?`, lastTimestamp, sessionID, chatJID, lastTimestamp); err != nil {
+				return ingested, perChat, pkgError.InternalServerError(fmt.Sprintf("failed to update chat %s: %v", chatJID, err))
+			}
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return ingested, perChat, pkgError.InternalServerError(fmt.Sprintf("failed to commit history tx: %v", err))
+	}
+	return ingested, perChat, nil
+}
+
+// messageText extracts the best-effort plain text body of a history sync
+// message for display purposes.
+func messageText(wmi *waWeb.WebMessageInfo) string {
+	msg := wmi.GetMessage()
+	if text := msg.GetConversation(); text != "" {
+		return text
+	}
+	return msg.GetExtendedTextMessage().GetText()
+}
+
+// mediaReference reports the media type and mime type carried by a history
+// sync message, if any. History sync doesn't include the attachment bytes
+// themselves, only enough metadata to know a message had one.
+func mediaReference(wmi *waWeb.WebMessageInfo) (mediaType, mimeType string, ok bool) {
+	msg := wmi.GetMessage()
+	switch {
+	case msg.GetImageMessage() != nil:
+		return "image", msg.GetImageMessage().GetMimetype(), true
+	case msg.GetVideoMessage() != nil:
+		return "video", msg.GetVideoMessage().GetMimetype(), true
+	case msg.GetAudioMessage() != nil:
+		return "audio", msg.GetAudioMessage().GetMimetype(), true
+	case msg.GetDocumentMessage() != nil:
+		return "document", msg.GetDocumentMessage().GetMimetype(), true
+	case msg.GetStickerMessage() != nil:
+		return "sticker", msg.GetStickerMessage().GetMimetype(), true
+	default:
+		return "", "", false
+	}
+}
+
+// sortGroupsFirst reorders conversations so group chats are ingested ahead
+// of 1:1 chats, since 1:1 history is more limited by WhatsApp's protocol
+// and groups are worth prioritizing when a backfill is time constrained.
+func sortGroupsFirst(conversations []*waHistorySync.Conversation) []*waHistorySync.Conversation {
+	out := make([]*waHistorySync.Conversation, 0, len(conversations))
+	var groups, direct []*waHistorySync.Conversation
+	for _, conv := range conversations {
+		if isGroupJID(conv.GetID()) {
+			groups = append(groups, conv)
+		} else {
+			direct = append(direct, conv)
+		}
+	}
+	out = append(out, groups...)
+	out = append(out, direct...)
+	return out
+}
+
+func isGroupJID(jid string) bool {
+	for i := 0; i < len(jid); i++ {
+		if jid[i] == '@' {
+			return jid[i:] == "@g.us"
+		}
+	}
+	return false
+}
+
+// ListChats returns every known chat for sessionID, most recently active
+// first.
+func ListChats(sessionID string) ([]Chat, error) {
+	rows, err := db.Query(
+		`SELECT session_id, jid, name, is_group, last_message_time FROM chats
+		 WHERE session_id = ? ORDER BY last_message_time DESC`,
+		sessionID,
+	)
+	if err != nil {
+		return nil, pkgError.InternalServerError(fmt.Sprintf("failed to list chats: %v", err))
+	}
+	defer rows.Close()
+
+	var out []Chat
+	for rows.Next() {
+		var c Chat
+		if err = rows.Scan(&c.SessionID, &c.JID, &c.Name, &c.IsGroup, &c.LastMessageTime); err != nil {
+			return nil, pkgError.InternalServerError(fmt.Sprintf("failed to scan chat row: %v", err))
+		}
+		out = append(out, c)
+	}
+	return out, nil
+}
+
+// ListMessages returns up to limit messages for chatJID in sessionID older
+// than before (or the newest ones, if before is the zero Cursor), along
+// with the cursor to pass as `before` on the next page.
+func ListMessages(sessionID, chatJID string, before Cursor, limit int) ([]Message, Cursor, error) {
+	if limit <= 0 {
+		limit = 50
+	}
+
+	var rows *sql.Rows
+	var err error
+	if before.Timestamp == 0 {
+		rows, err = db.Query(
+			`SELECT id, session_id, chat_jid, sender_jid, from_me, text, timestamp FROM messages
+			 WHERE session_id = ? AND chat_jid = ? ORDER BY timestamp DESC LIMIT ?`,
+			sessionID, chatJID, limit,
+		)
+	} else {
+		rows, err = db.Query(
+			`SELECT id, session_id, chat_jid, sender_jid, from_me, text, timestamp FROM messages
+			 WHERE session_id = ? AND chat_jid = ? AND timestamp < ? ORDER BY timestamp DESC LIMIT ?`,
+			sessionID, chatJID, before.Timestamp, limit,
+		)
+	}
+	if err != nil {
+		return nil, Cursor{}, pkgError.InternalServerError(fmt.Sprintf("failed to list messages: %v", err))
+	}
+	defer rows.Close()
+
+	var out []Message
+	for rows.Next() {
+		var m Message
+		if err = rows.Scan(&m.ID, &m.SessionID, &m.ChatJID, &m.SenderJID, &m.FromMe, &m.Text, &m.Timestamp); err != nil {
+			return nil, Cursor{}, pkgError.InternalServerError(fmt.Sprintf("failed to scan message row: %v", err))
+		}
+		out = append(out, m)
+	}
+
+	var next Cursor
+	if len(out) == limit {
+		last := out[len(out)-1]
+		next = Cursor{MessageID: last.ID, Timestamp: last.Timestamp}
+	}
+	return out, next, nil
+}
+
+// ListParticipants returns every group chat member that has appeared as a
+// message sender for chatJID in sessionID.
+func ListParticipants(sessionID, chatJID string) ([]string, error) {
+	rows, err := db.Query(
+		`SELECT jid FROM participants WHERE session_id = ? AND chat_jid = ? ORDER BY jid`,
+		sessionID, chatJID,
+	)
+	if err != nil {
+		return nil, pkgError.InternalServerError(fmt.Sprintf("failed to list participants: %v", err))
+	}
+	defer rows.Close()
+
+	var out []string
+	for rows.Next() {
+		var jid string
+		if err = rows.Scan(&jid); err != nil {
+			return nil, pkgError.InternalServerError(fmt.Sprintf("failed to scan participant row: %v", err))
+		}
+		out = append(out, jid)
+	}
+	return out, nil
+}
+
+// ListMediaReferences returns every media attachment reference for chatJID
+// in sessionID, most recent message first.
+func ListMediaReferences(sessionID, chatJID string) ([]MediaReference, error) {
+	rows, err := db.Query(
+		`SELECT mr.session_id, mr.chat_jid, mr.message_id, mr.media_type, mr.mime_type FROM media_references mr
+		 JOIN messages m ON m.session_id = mr.session_id AND m.chat_jid = mr.chat_jid AND m.id = mr.message_id
+		 WHERE mr.session_id = ? AND mr.chat_jid = ? ORDER BY m.timestamp DESC`,
+		sessionID, chatJID,
+	)
+	if err != nil {
+		return nil, pkgError.InternalServerError(fmt.Sprintf("failed to list media references: %v", err))
+	}
+	defer rows.Close()
+
+	var out []MediaReference
+	for rows.Next() {
+		var m MediaReference
+		if err = rows.Scan(&m.SessionID, &m.ChatJID, &m.MessageID, &m.MediaType, &m.MimeType); err != nil {
+			return nil, pkgError.InternalServerError(fmt.Sprintf("failed to scan media reference row: %v", err))
+		}
+		out = append(out, m)
+	}
+	return out, nil
+}
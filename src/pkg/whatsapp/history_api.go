@@ -0,0 +1,279 @@
+package whatsapp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/aldinokemal/go-whatsapp-web-multidevice/config"
+	pkgError "github.com/aldinokemal/go-whatsapp-web-multidevice/pkg/error"
+	"github.com/aldinokemal/go-whatsapp-web-multidevice/pkg/whatsapp/history"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// defaultBackfillTimeout bounds how long POST /history/request blocks
+// waiting for the matching HistorySync event to arrive.
+const defaultBackfillTimeout = 30 * time.Second
+
+// InitHistory opens the history database. It must be called once during
+// startup, after config is loaded.
+func InitHistory() error {
+	return history.Init(fmt.Sprintf("%s/history.db", config.PathStorages))
+}
+
+// ingestHistorySync normalizes an incoming HistorySync payload and wakes up
+// any pending on-demand backfill request for a chat this particular sync
+// actually touched, leaving waiters for other chats blocked until their own
+// matching sync arrives.
+func (s *Session) ingestHistorySync(evt *events.HistorySync) {
+	ingested, perChat, err := history.Ingest(s.ID, evt.Data)
+	if err != nil {
+		s.Log.Errorf("Failed to ingest history sync: %v", err)
+		return
+	}
+	s.Log.Infof("Ingested %d messages from a %s history sync", ingested, evt.Data.GetSyncType().String())
+
+	s.pendingBackfillsMu.Lock()
+	for chatJID, count := range perChat {
+		waiters, ok := s.pendingBackfills[chatJID]
+		if !ok {
+			continue
+		}
+		for _, ch := range waiters {
+			select {
+			case ch <- count:
+			default:
+			}
+		}
+		delete(s.pendingBackfills, chatJID)
+	}
+	s.pendingBackfillsMu.Unlock()
+}
+
+// registerBackfillWaiter adds ch to chatJID's waiter list.
+func (s *Session) registerBackfillWaiter(chatJID string, ch chan int) {
+	s.pendingBackfillsMu.Lock()
+	s.pendingBackfills[chatJID] = append(s.pendingBackfills[chatJID], ch)
+	s.pendingBackfillsMu.Unlock()
+}
+
+// unregisterBackfillWaiter removes ch from chatJID's waiter list, so a
+// request that failed to send its sync request or timed out waiting
+// doesn't leak an entry forever.
+func (s *Session) unregisterBackfillWaiter(chatJID string, ch chan int) {
+	s.pendingBackfillsMu.Lock()
+	defer s.pendingBackfillsMu.Unlock()
+
+	waiters := s.pendingBackfills[chatJID]
+	for i, w := range waiters {
+		if w == ch {
+			s.pendingBackfills[chatJID] = append(waiters[:i], waiters[i+1:]...)
+			break
+		}
+	}
+	if len(s.pendingBackfills[chatJID]) == 0 {
+		delete(s.pendingBackfills, chatJID)
+	}
+}
+
+// RegisterHistoryRoutes wires the history endpoints onto mux, each scoped
+// to the session named by its ?session_id= query parameter:
+//
+//	GET  /history/chats?session_id=                            list known chats
+//	GET  /history/messages?session_id=&chat_jid=&before=&limit= paginated message history
+//	GET  /history/participants?session_id=&chat_jid=            known group members
+//	GET  /history/media?session_id=&chat_jid=                   media attachment references
+//	POST /history/request?session_id=                          on-demand backfill
+func RegisterHistoryRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/history/chats", listChatsHandler)
+	mux.HandleFunc("/history/messages", listMessagesHandler)
+	mux.HandleFunc("/history/participants", listParticipantsHandler)
+	mux.HandleFunc("/history/media", listMediaReferencesHandler)
+	mux.HandleFunc("/history/request", requestBackfillHandler)
+}
+
+// sessionFromRequest resolves the Session named by the request's
+// ?session_id= query parameter, writing an error response and returning
+// ok=false if it isn't live.
+func sessionFromRequest(w http.ResponseWriter, r *http.Request) (*Session, bool) {
+	sessionID := r.URL.Query().Get("session_id")
+	if sessionID == "" {
+		http.Error(w, "session_id is required", http.StatusBadRequest)
+		return nil, false
+	}
+	session, ok := manager.Get(sessionID)
+	if !ok {
+		http.Error(w, fmt.Sprintf("session %s not found", sessionID), http.StatusNotFound)
+		return nil, false
+	}
+	return session, true
+}
+
+func listChatsHandler(w http.ResponseWriter, r *http.Request) {
+	session, ok := sessionFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	chats, err := history.ListChats(session.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(chats)
+}
+
+func listMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	session, ok := sessionFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	chatJID := r.URL.Query().Get("chat_jid")
+	if chatJID == "" {
+		http.Error(w, "chat_jid is required", http.StatusBadRequest)
+		return
+	}
+
+	before, err := history.DecodeCursor(r.URL.Query().Get("before"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	limit := 50
+	if l := r.URL.Query().Get("limit"); l != "" {
+		if limit, err = strconv.Atoi(l); err != nil {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+
+	messages, next, err := history.ListMessages(session.ID, chatJID, before, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	resp := map[string]interface{}{"messages": messages}
+	if next != (history.Cursor{}) {
+		resp["cursor"] = history.EncodeCursor(next)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func listParticipantsHandler(w http.ResponseWriter, r *http.Request) {
+	session, ok := sessionFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	chatJID := r.URL.Query().Get("chat_jid")
+	if chatJID == "" {
+		http.Error(w, "chat_jid is required", http.StatusBadRequest)
+		return
+	}
+
+	participants, err := history.ListParticipants(session.ID, chatJID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"participants": participants})
+}
+
+func listMediaReferencesHandler(w http.ResponseWriter, r *http.Request) {
+	session, ok := sessionFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	chatJID := r.URL.Query().Get("chat_jid")
+	if chatJID == "" {
+		http.Error(w, "chat_jid is required", http.StatusBadRequest)
+		return
+	}
+
+	media, err := history.ListMediaReferences(session.ID, chatJID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(media)
+}
+
+// requestBackfillRequest is the body of POST /history/request.
+type requestBackfillRequest struct {
+	ChatJID string `json:"chat_jid"`
+}
+
+// requestBackfillHandler triggers an on-demand backfill for a given chat by
+// building and sending a history sync request, then blocking until the
+// matching HistorySync event arrives (or defaultBackfillTimeout elapses)
+// before returning the newly-ingested messages.
+func requestBackfillHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	session, ok := sessionFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var req requestBackfillRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.ChatJID == "" {
+		http.Error(w, "chat_jid is required", http.StatusBadRequest)
+		return
+	}
+
+	jid, err := ParseJID(req.ChatJID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Key pendingBackfills by the same normalized JID ingestHistorySync
+	// matches against (conv.GetID()), not the raw request body value,
+	// since they may differ (e.g. a bare phone number vs a full JID).
+	chatJID := jid.String()
+
+	ch := make(chan int, 1)
+	session.registerBackfillWaiter(chatJID, ch)
+
+	syncReq := session.Client.BuildHistorySyncRequest(nil, 50)
+	if _, err = session.Client.SendMessage(context.Background(), jid, syncReq); err != nil {
+		session.unregisterBackfillWaiter(chatJID, ch)
+		http.Error(w, pkgError.WebhookError(fmt.Sprintf("failed to request history sync: %v", err)).Error(), http.StatusInternalServerError)
+		return
+	}
+
+	select {
+	case ingested := <-ch:
+		// Nothing new arrived for this chat; don't call ListMessages
+		// with limit=0, which it treats as "unspecified" and falls
+		// back to the newest 50 existing messages instead of an empty
+		// "nothing new" result.
+		messages := []history.Message{}
+		if ingested > 0 {
+			messages, _, _ = history.ListMessages(session.ID, chatJID, history.Cursor{}, ingested)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"ingested": ingested, "messages": messages})
+	case <-time.After(defaultBackfillTimeout):
+		session.unregisterBackfillWaiter(chatJID, ch)
+		http.Error(w, "timed out waiting for history sync", http.StatusGatewayTimeout)
+	}
+}
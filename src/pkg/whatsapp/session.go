@@ -0,0 +1,343 @@
+package whatsapp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aldinokemal/go-whatsapp-web-multidevice/config"
+	pkgError "github.com/aldinokemal/go-whatsapp-web-multidevice/pkg/error"
+	"github.com/google/uuid"
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/store"
+	"go.mau.fi/whatsmeow/store/sqlstore"
+	"go.mau.fi/whatsmeow/types"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// pairTimeout bounds how long POST /sessions blocks waiting for a QR code
+// to be generated before giving up.
+const pairTimeout = 30 * time.Second
+
+// Session owns one WhatsApp connection. Everything that used to live on
+// package-level vars (the client, its logger, the joined-groups cache, and
+// the in-flight backfill requests) now hangs off the session that owns it,
+// so a single process can run several WhatsApp numbers side by side.
+type Session struct {
+	ID     string
+	Client *whatsmeow.Client
+	Log    waLog.Logger
+
+	joinedGroupsMu sync.RWMutex
+	joinedGroups   []types.JID
+
+	// pendingBackfillsMu guards pendingBackfills, a per-chat list of
+	// waiter channels: several concurrent POST /history/request calls for
+	// the same chat each get their own channel instead of clobbering one
+	// another, and ingestHistorySync notifies every waiter for a chat it
+	// touches.
+	pendingBackfillsMu sync.Mutex
+	pendingBackfills   map[string][]chan int
+
+	contactsMu sync.RWMutex
+	contacts   map[types.JID]types.ContactInfo
+
+	avatarsMu     sync.RWMutex
+	avatars       map[types.JID]*types.ProfilePictureInfo
+	avatarFetchMu sync.Mutex
+}
+
+// SessionManager owns every live Session, keyed by session ID.
+type SessionManager struct {
+	mu        sync.RWMutex
+	container *sqlstore.Container
+	sessions  map[string]*Session
+}
+
+var manager *SessionManager
+
+// NewSessionManager creates the manager that owns every session backed by
+// storeContainer. Call RestoreSessions afterwards to reconnect every
+// device the container already knows about.
+func NewSessionManager(storeContainer *sqlstore.Container) *SessionManager {
+	manager = &SessionManager{
+		container: storeContainer,
+		sessions:  map[string]*Session{},
+	}
+	return manager
+}
+
+// RestoreSessions reconnects one Session per device already persisted in
+// the sqlstore container, the same way the old single-session InitWaCLI
+// restored the first device on startup.
+func (m *SessionManager) RestoreSessions() error {
+	devices, err := m.container.GetAllDevices()
+	if err != nil {
+		return pkgError.InternalServerError(fmt.Sprintf("failed to list devices: %v", err))
+	}
+
+	for _, device := range devices {
+		sessionID := device.ID.String()
+		session := m.newSession(sessionID, device)
+		if err = session.Client.Connect(); err != nil {
+			session.Log.Errorf("Failed to connect: %v", err)
+			continue
+		}
+		m.put(session)
+	}
+	return nil
+}
+
+// StartSession begins a fresh pairing flow: it creates a new, empty device,
+// connects it, and returns the session plus a channel of QR codes to show
+// the user. The channel is closed once pairing succeeds, fails, or times
+// out.
+func (m *SessionManager) StartSession(ctx context.Context, sessionID string) (*Session, <-chan string, error) {
+	device := m.container.NewDevice()
+	session := m.newSession(sessionID, device)
+
+	qrChan, err := session.Client.GetQRChannel(ctx)
+	if err != nil {
+		return nil, nil, pkgError.InternalServerError(fmt.Sprintf("failed to get QR channel: %v", err))
+	}
+	if err = session.Client.Connect(); err != nil {
+		return nil, nil, pkgError.InternalServerError(fmt.Sprintf("failed to connect: %v", err))
+	}
+
+	codes := make(chan string, 1)
+	go func() {
+		defer close(codes)
+		for evt := range qrChan {
+			if evt.Event != "code" {
+				continue
+			}
+			// codes only has one reader, and only for the first code:
+			// a non-blocking send keeps draining qrChan (whatsmeow
+			// re-emits a fresh code roughly every 20-30s while waiting
+			// to be scanned) instead of blocking forever on the second
+			// one with no one left to receive it.
+			select {
+			case codes <- evt.Code:
+			default:
+			}
+		}
+	}()
+
+	m.put(session)
+	return session, codes, nil
+}
+
+// rekey moves a session from its temporary pairing ID to the stable device
+// JID whatsmeow assigned it once pairing succeeds, which is the same ID
+// RestoreSessions gives it after a restart. The temporary ID returned by
+// POST /sessions no longer resolves once the session has been rekeyed.
+func (m *SessionManager) rekey(oldID string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	session, ok := m.sessions[oldID]
+	if !ok {
+		return oldID
+	}
+
+	newID := session.Client.Store.ID.String()
+	if newID == oldID {
+		return oldID
+	}
+
+	delete(m.sessions, oldID)
+	session.ID = newID
+	m.sessions[newID] = session
+	return newID
+}
+
+// Get returns the session for id, if it is currently live.
+func (m *SessionManager) Get(id string) (*Session, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	s, ok := m.sessions[id]
+	return s, ok
+}
+
+// List returns every live session ID.
+func (m *SessionManager) List() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	ids := make([]string, 0, len(m.sessions))
+	for id := range m.sessions {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// errSessionNotFound is returned by Remove when id isn't a live session, so
+// callers (sessionHandler) can tell that case apart from a removal that
+// happened but failed to fully wipe the store row.
+var errSessionNotFound = errors.New("session not found")
+
+// Remove logs the session out, wipes its store row, and drops it from the
+// manager. The session is always dropped from the manager even if the
+// store wipe fails, so a device that's already unlinked or unreachable
+// doesn't get stuck registered forever; a wipe failure is still reported
+// to the caller as an error.
+func (m *SessionManager) Remove(id string) error {
+	session, ok := m.Get(id)
+	if !ok {
+		return fmt.Errorf("%w: %s", errSessionNotFound, id)
+	}
+
+	// Logout already wipes the device's store row on success. Only force
+	// an explicit delete when it fails (e.g. the device was already
+	// unlinked or unreachable), so a session in that state still gets
+	// its row cleared.
+	var wipeErr error
+	if err := session.Client.Logout(context.Background()); err != nil {
+		session.Log.Warnf("logout failed during removal, forcing a store wipe: %v", err)
+		wipeErr = session.Client.Store.Delete(context.Background())
+	}
+
+	m.mu.Lock()
+	delete(m.sessions, id)
+	m.mu.Unlock()
+
+	if wipeErr != nil {
+		return pkgError.InternalServerError(fmt.Sprintf("session %s removed, but its store row could not be wiped: %v", id, wipeErr))
+	}
+	return nil
+}
+
+// discard disconnects an abandoned, never-paired session's client and drops
+// it from the manager. Unlike Remove, it doesn't log out or wipe a store
+// row: the session's device was never successfully paired, so nothing was
+// ever persisted for it.
+func (m *SessionManager) discard(id string) {
+	m.mu.Lock()
+	session, ok := m.sessions[id]
+	if ok && session.Client.Store.ID != nil {
+		// Pairing actually succeeded in the narrow window between the
+		// timeout firing and this call acquiring the lock. Leave the
+		// session registered under id for rekey to claim instead of
+		// discarding a client that just finished pairing.
+		m.mu.Unlock()
+		return
+	}
+	delete(m.sessions, id)
+	m.mu.Unlock()
+
+	if ok {
+		session.Client.Disconnect()
+	}
+}
+
+// newSession wires up a client for device, including its event handler and
+// per-session caches, without connecting it yet.
+func (m *SessionManager) newSession(sessionID string, device *store.Device) *Session {
+	osName := fmt.Sprintf("%s %s", config.AppOs, config.AppVersion)
+	store.DeviceProps.PlatformType = &config.AppPlatform
+	store.DeviceProps.Os = &osName
+
+	session := &Session{
+		ID:               sessionID,
+		Client:           whatsmeow.NewClient(device, waLog.Stdout(fmt.Sprintf("Client[%s]", sessionID), config.WhatsappLogLevel, true)),
+		Log:              waLog.Stdout(fmt.Sprintf("Session[%s]", sessionID), config.WhatsappLogLevel, true),
+		pendingBackfills: map[string][]chan int{},
+		contacts:         map[types.JID]types.ContactInfo{},
+		avatars:          map[types.JID]*types.ProfilePictureInfo{},
+	}
+	session.Client.EnableAutoReconnect = true
+	session.Client.AutoTrustIdentity = true
+	session.Client.AddEventHandler(session.handler)
+
+	return session
+}
+
+func (m *SessionManager) put(session *Session) {
+	m.mu.Lock()
+	m.sessions[session.ID] = session
+	m.mu.Unlock()
+}
+
+// RegisterSessionRoutes wires the session-management endpoints onto mux:
+//
+//	POST   /sessions       start a new pairing flow, returns session id + QR
+//	GET    /sessions       list live session ids
+//	DELETE /sessions/{id}  log out and drop a session
+func (m *SessionManager) RegisterSessionRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/sessions", m.sessionsHandler)
+	mux.HandleFunc("/sessions/", m.sessionHandler)
+}
+
+func (m *SessionManager) sessionsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"sessions": m.List()})
+	case http.MethodPost:
+		m.startSessionHandler(w, r)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (m *SessionManager) startSessionHandler(w http.ResponseWriter, r *http.Request) {
+	sessionID := uuid.NewString()
+
+	ctx, cancel := context.WithTimeout(context.Background(), pairTimeout)
+	defer cancel()
+
+	_, codes, err := m.StartSession(ctx, sessionID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	select {
+	case code, ok := <-codes:
+		if !ok {
+			// The QR channel closed without ever producing a code (e.g.
+			// a connection error before whatsmeow generated one); the
+			// session never has a chance to pair, so drop it the same
+			// way an abandoned pairing timeout does.
+			m.discard(sessionID)
+			http.Error(w, "pairing flow ended before producing a QR code", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"session_id": sessionID, "qr_code": code})
+	case <-ctx.Done():
+		// The QR was never scanned: drop the half-paired session instead
+		// of leaving its connected client (and goroutine) registered
+		// forever with no way for a caller to clean it up.
+		m.discard(sessionID)
+		http.Error(w, "timed out waiting for QR code", http.StatusGatewayTimeout)
+	}
+}
+
+func (m *SessionManager) sessionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sessionID := strings.TrimPrefix(r.URL.Path, "/sessions/")
+	if sessionID == "" {
+		http.Error(w, "session id is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := m.Remove(sessionID); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, errSessionNotFound) {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
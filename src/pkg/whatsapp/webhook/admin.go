@@ -0,0 +1,54 @@
+package webhook
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// RegisterRoutes wires the webhook admin endpoints onto mux:
+//
+//	GET  /webhooks/dlq           list parked deliveries
+//	POST /webhooks/dlq/{id}/requeue  re-submit one of them
+func RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/webhooks/dlq", listDeadLettersHandler)
+	mux.HandleFunc("/webhooks/dlq/", requeueHandler)
+}
+
+func listDeadLettersHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	entries, err := ListDeadLetters()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(entries)
+}
+
+func requeueHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || !strings.HasSuffix(r.URL.Path, "/requeue") {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	idStr := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/webhooks/dlq/"), "/requeue")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		http.Error(w, "invalid dlq id", http.StatusBadRequest)
+		return
+	}
+
+	if err = Requeue(id); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,126 @@
+package webhook
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	pkgError "github.com/aldinokemal/go-whatsapp-web-multidevice/pkg/error"
+)
+
+// DeadLetter is a payload parked for one URL: either it exhausted every
+// retry attempt, or its URL was no longer configured when the persisted
+// queue was replayed (then no attempt was made and Attempts is 0).
+type DeadLetter struct {
+	ID        int64     `json:"id"`
+	EventID   string    `json:"event_id"`
+	URL       string    `json:"url"`
+	EventType string    `json:"event_type"`
+	Envelope  string    `json:"envelope"`
+	LastError string    `json:"last_error"`
+	Attempts  int       `json:"attempts"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func ensureSchema(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS webhook_dlq (
+			id         INTEGER PRIMARY KEY AUTOINCREMENT,
+			event_id   TEXT NOT NULL,
+			url        TEXT NOT NULL,
+			event_type TEXT NOT NULL,
+			envelope   TEXT NOT NULL,
+			last_error TEXT NOT NULL,
+			attempts   INTEGER NOT NULL,
+			created_at DATETIME NOT NULL
+		);
+		CREATE TABLE IF NOT EXISTS webhook_queue (
+			id         INTEGER PRIMARY KEY AUTOINCREMENT,
+			event_id   TEXT NOT NULL,
+			url        TEXT NOT NULL,
+			event_type TEXT NOT NULL,
+			envelope   TEXT NOT NULL,
+			created_at DATETIME NOT NULL
+		)
+	`)
+	if err != nil {
+		return pkgError.InternalServerError(fmt.Sprintf("failed to create webhook schema: %v", err))
+	}
+	return nil
+}
+
+// deadLetter persists a permanently-failed job so it can be inspected and
+// requeued later through the admin endpoints.
+func (q *Queue) deadLetter(j job, lastErr error) error {
+	envelope, err := json.Marshal(j.envelope)
+	if err != nil {
+		return pkgError.InternalServerError(fmt.Sprintf("failed to marshal envelope for dlq: %v", err))
+	}
+
+	attempts := maxAttempts
+	if _, ok := q.lanes[j.url]; !ok {
+		attempts = 0
+	}
+
+	_, err = q.db.Exec(
+		`INSERT INTO webhook_dlq (event_id, url, event_type, envelope, last_error, attempts, created_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		j.eventID, j.url, j.envelope.EventType, string(envelope), lastErr.Error(), attempts, time.Now(),
+	)
+	if err != nil {
+		return pkgError.InternalServerError(fmt.Sprintf("failed to insert webhook_dlq row: %v", err))
+	}
+	return nil
+}
+
+// ListDeadLetters returns every parked payload, most recent first.
+func ListDeadLetters() ([]DeadLetter, error) {
+	if q == nil {
+		return nil, nil
+	}
+
+	rows, err := q.db.Query(`SELECT id, event_id, url, event_type, envelope, last_error, attempts, created_at
+	                          FROM webhook_dlq ORDER BY id DESC`)
+	if err != nil {
+		return nil, pkgError.InternalServerError(fmt.Sprintf("failed to list webhook_dlq: %v", err))
+	}
+	defer rows.Close()
+
+	var out []DeadLetter
+	for rows.Next() {
+		var d DeadLetter
+		if err = rows.Scan(&d.ID, &d.EventID, &d.URL, &d.EventType, &d.Envelope, &d.LastError, &d.Attempts, &d.CreatedAt); err != nil {
+			return nil, pkgError.InternalServerError(fmt.Sprintf("failed to scan webhook_dlq row: %v", err))
+		}
+		out = append(out, d)
+	}
+	return out, nil
+}
+
+// Requeue re-submits a parked payload to its original URL and removes it
+// from the dead-letter table on success.
+func Requeue(id int64) error {
+	if q == nil {
+		return pkgError.InternalServerError("webhook queue is not initialized")
+	}
+
+	var d DeadLetter
+	var envelope Envelope
+	row := q.db.QueryRow(`SELECT event_id, url, envelope FROM webhook_dlq WHERE id = ?`, id)
+	if err := row.Scan(&d.EventID, &d.URL, &d.Envelope); err != nil {
+		return pkgError.InternalServerError(fmt.Sprintf("failed to load dlq entry %d: %v", id, err))
+	}
+	if err := json.Unmarshal([]byte(d.Envelope), &envelope); err != nil {
+		return pkgError.InternalServerError(fmt.Sprintf("failed to unmarshal dlq envelope %d: %v", id, err))
+	}
+
+	if err := q.deliver(job{eventID: d.EventID, url: d.URL, envelope: envelope}); err != nil {
+		return err
+	}
+
+	if _, err := q.db.Exec(`DELETE FROM webhook_dlq WHERE id = ?`, id); err != nil {
+		return pkgError.InternalServerError(fmt.Sprintf("failed to delete dlq entry %d: %v", id, err))
+	}
+	return nil
+}
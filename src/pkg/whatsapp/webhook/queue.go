@@ -0,0 +1,136 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	pkgError "github.com/aldinokemal/go-whatsapp-web-multidevice/pkg/error"
+	"github.com/sirupsen/logrus"
+)
+
+// sweepInterval is how often a Queue re-scans webhook_queue for rows that
+// didn't fit in a lane (because it was full) the first time they were
+// enqueued, so a slow or briefly-down URL doesn't lose events.
+const sweepInterval = 30 * time.Second
+
+// persistQueued durably records one (event, url) delivery before it is
+// handed to a lane, so a crash or restart with jobs still in flight
+// doesn't lose them: recoverPending replays whatever is still here.
+func (q *Queue) persistQueued(eventID, url, eventType string, envelope []byte) (int64, error) {
+	res, err := q.db.Exec(
+		`INSERT INTO webhook_queue (event_id, url, event_type, envelope, created_at) VALUES (?, ?, ?, ?, ?)`,
+		eventID, url, eventType, string(envelope), time.Now(),
+	)
+	if err != nil {
+		return 0, pkgError.InternalServerError(fmt.Sprintf("failed to persist webhook queue row: %v", err))
+	}
+	return res.LastInsertId()
+}
+
+// removeFromQueue deletes a delivered (or permanently failed) row and
+// clears its in-flight marker.
+func (q *Queue) removeFromQueue(queueID int64) {
+	if _, err := q.db.Exec(`DELETE FROM webhook_queue WHERE id = ?`, queueID); err != nil {
+		logrus.Errorf("failed to delete webhook queue row %d: %v", queueID, err)
+	}
+
+	q.inFlightMu.Lock()
+	delete(q.inFlight, queueID)
+	q.inFlightMu.Unlock()
+}
+
+// parkOrphaned moves a queued row whose URL is no longer configured into
+// webhook_dlq, so the payload is still inspectable/requeueable via the
+// admin endpoints instead of being discarded outright, then removes it
+// from webhook_queue.
+func (q *Queue) parkOrphaned(queueID int64, eventID, url, envelope string) {
+	var decoded Envelope
+	if err := json.Unmarshal([]byte(envelope), &decoded); err != nil {
+		logrus.Errorf("failed to unmarshal orphaned webhook queue row %d: %v", queueID, err)
+		q.removeFromQueue(queueID)
+		return
+	}
+
+	if err := q.deadLetter(job{eventID: eventID, url: url, envelope: decoded}, fmt.Errorf("url %s is no longer configured", url)); err != nil {
+		logrus.Errorf("failed to park orphaned webhook queue row %d: %v", queueID, err)
+	}
+	q.removeFromQueue(queueID)
+}
+
+// recoverPending loads every row still sitting in webhook_queue and feeds
+// the ones that aren't already in flight into their URL's lane. It runs
+// once at startup (to replay whatever survived a crash) and again on
+// every sweepInterval tick (to pick up rows that were persisted but
+// dropped by Enqueue because their lane was momentarily full).
+func (q *Queue) recoverPending() {
+	rows, err := q.db.Query(`SELECT id, event_id, url, envelope FROM webhook_queue ORDER BY id`)
+	if err != nil {
+		logrus.Errorf("failed to read persisted webhook queue: %v", err)
+		return
+	}
+
+	type pendingRow struct {
+		id       int64
+		eventID  string
+		url      string
+		envelope string
+	}
+	var pending []pendingRow
+	for rows.Next() {
+		var p pendingRow
+		if err = rows.Scan(&p.id, &p.eventID, &p.url, &p.envelope); err != nil {
+			logrus.Errorf("failed to scan persisted webhook queue row: %v", err)
+			continue
+		}
+		pending = append(pending, p)
+	}
+	rows.Close()
+
+	for _, p := range pending {
+		q.inFlightMu.Lock()
+		if q.inFlight[p.id] {
+			q.inFlightMu.Unlock()
+			continue
+		}
+		q.inFlight[p.id] = true
+		q.inFlightMu.Unlock()
+
+		lane, ok := q.lanes[p.url]
+		if !ok {
+			// The URL is no longer configured; nothing can ever deliver
+			// this row, but park it in the dlq instead of silently
+			// discarding it so operators still see it happened.
+			q.parkOrphaned(p.id, p.eventID, p.url, p.envelope)
+			continue
+		}
+
+		var envelope Envelope
+		if err = json.Unmarshal([]byte(p.envelope), &envelope); err != nil {
+			logrus.Errorf("failed to unmarshal persisted webhook queue row %d: %v", p.id, err)
+			q.removeFromQueue(p.id)
+			continue
+		}
+
+		select {
+		case lane <- job{queueID: p.id, eventID: p.eventID, url: p.url, envelope: envelope}:
+		default:
+			// The lane is still full; leave the row persisted and
+			// not-in-flight so the next sweep retries it instead of
+			// blocking this goroutine (and every sweep after it).
+			q.inFlightMu.Lock()
+			delete(q.inFlight, p.id)
+			q.inFlightMu.Unlock()
+		}
+	}
+}
+
+// sweepLoop periodically calls recoverPending so nothing persisted ever
+// gets stuck behind a lane that was briefly full.
+func (q *Queue) sweepLoop() {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		q.recoverPending()
+	}
+}
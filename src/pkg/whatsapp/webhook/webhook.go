@@ -0,0 +1,242 @@
+// Package webhook is the delivery subsystem for outbound WhatsApp events.
+// It replaces the old fire-and-forget forwardToWebhook/forwardReceipt
+// helpers with a persistent, per-URL fan-out queue that signs every
+// payload, retries on failure, and parks permanently-failing deliveries
+// in a dead-letter table instead of dropping them.
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aldinokemal/go-whatsapp-web-multidevice/config"
+	pkgError "github.com/aldinokemal/go-whatsapp-web-multidevice/pkg/error"
+	"github.com/google/uuid"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// maxAttempts is how many delivery attempts (the first try included)
+	// a single URL gets before the payload is moved to the dead-letter queue.
+	maxAttempts = 5
+	baseBackoff = 2 * time.Second
+	maxBackoff  = 2 * time.Minute
+	queueSize   = 256
+)
+
+// Envelope is the typed event shared by every webhook-able event source
+// (messages, receipts, presence, group events, ...), so they can all ride
+// the same delivery path.
+type Envelope struct {
+	SessionID string      `json:"session_id"`
+	EventType string      `json:"event_type"`
+	Timestamp time.Time   `json:"timestamp"`
+	ChatJID   string      `json:"chat_jid"`
+	SenderJID string      `json:"sender_jid"`
+	Payload   interface{} `json:"payload"`
+}
+
+// job is a single (url, envelope) delivery unit. queueID identifies its
+// row in webhook_queue, the durable record that survives until the job
+// is delivered or dead-lettered.
+type job struct {
+	queueID  int64
+	eventID  string
+	url      string
+	envelope Envelope
+}
+
+// Queue fans events out to every configured webhook URL, one worker
+// goroutine (and its own retry loop) per URL. Every job is persisted to
+// webhook_queue before it reaches a lane, so a crash or restart with jobs
+// still buffered doesn't lose them; inFlight tracks which persisted rows
+// are already owned by a lane so recoverPending doesn't hand them out
+// twice.
+type Queue struct {
+	db     *sql.DB
+	urls   []string
+	secret string
+	client *http.Client
+	lanes  map[string]chan job
+
+	inFlightMu sync.Mutex
+	inFlight   map[int64]bool
+}
+
+var q *Queue
+
+// Init opens the delivery database, makes sure its schema exists, and
+// starts one worker per URL in config.WhatsappWebhook. It must be called
+// once during startup, after config is loaded.
+func Init() error {
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s/webhook.db?_foreign_keys=off", config.PathStorages))
+	if err != nil {
+		return pkgError.InternalServerError(fmt.Sprintf("failed to open webhook db: %v", err))
+	}
+	if err = ensureSchema(db); err != nil {
+		return err
+	}
+
+	queue := &Queue{
+		db:       db,
+		urls:     config.WhatsappWebhook,
+		secret:   config.WhatsappWebhookSecret,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		lanes:    make(map[string]chan job, len(config.WhatsappWebhook)),
+		inFlight: make(map[int64]bool),
+	}
+
+	for _, url := range queue.urls {
+		lane := make(chan job, queueSize)
+		queue.lanes[url] = lane
+		go queue.worker(url, lane)
+	}
+
+	q = queue
+
+	// Replay whatever was still sitting in webhook_queue from before a
+	// crash or restart, then keep sweeping for anything a full lane
+	// dropped back onto it.
+	queue.recoverPending()
+	go queue.sweepLoop()
+
+	return nil
+}
+
+// Enqueue fans the given event out to every configured webhook URL. It is
+// a no-op when no webhook URL is configured, so callers don't need to
+// guard on config.WhatsappWebhook themselves.
+func Enqueue(sessionID, eventType, chatJID, senderJID string, payload interface{}) {
+	if q == nil || len(q.lanes) == 0 {
+		return
+	}
+
+	envelope := Envelope{
+		SessionID: sessionID,
+		EventType: eventType,
+		Timestamp: time.Now(),
+		ChatJID:   chatJID,
+		SenderJID: senderJID,
+		Payload:   payload,
+	}
+	eventID := uuid.NewString()
+
+	envelopeJSON, err := json.Marshal(envelope)
+	if err != nil {
+		logrus.Errorf("failed to marshal webhook envelope %s: %v", eventID, err)
+		return
+	}
+
+	for url, lane := range q.lanes {
+		queueID, err := q.persistQueued(eventID, url, eventType, envelopeJSON)
+		if err != nil {
+			// Couldn't durably record it (e.g. a transient sqlite busy
+			// error); still try the in-memory lane rather than losing
+			// the event outright. queueID stays 0, which worker's
+			// removeFromQueue call treats as a no-op.
+			logrus.Errorf("failed to persist webhook event %s for %s, falling back to in-memory delivery: %v", eventID, url, err)
+		} else {
+			q.inFlightMu.Lock()
+			q.inFlight[queueID] = true
+			q.inFlightMu.Unlock()
+		}
+
+		select {
+		case lane <- job{queueID: queueID, eventID: eventID, url: url, envelope: envelope}:
+		default:
+			q.inFlightMu.Lock()
+			delete(q.inFlight, queueID)
+			q.inFlightMu.Unlock()
+			if queueID != 0 {
+				// The row is already durably queued in webhook_queue;
+				// the next sweep picks it up instead of losing it.
+				logrus.Warnf("webhook lane for %s is full, event %s parked in the persisted queue", url, eventID)
+			} else {
+				logrus.Warnf("webhook lane for %s is full, dropping event %s (not persisted)", url, eventID)
+			}
+		}
+	}
+}
+
+// worker delivers jobs for a single URL in order, retrying with
+// exponential backoff and jitter until maxAttempts is exhausted, at which
+// point the payload is parked in the dead-letter table.
+func (q *Queue) worker(url string, lane chan job) {
+	for j := range lane {
+		var lastErr error
+		for attempt := 1; attempt <= maxAttempts; attempt++ {
+			if err := q.deliver(j); err != nil {
+				lastErr = err
+				logrus.Warnf("webhook delivery to %s failed (attempt %d/%d): %v", url, attempt, maxAttempts, err)
+				time.Sleep(backoffWithJitter(attempt))
+				continue
+			}
+			lastErr = nil
+			break
+		}
+
+		if lastErr != nil {
+			if err := q.deadLetter(j, lastErr); err != nil {
+				logrus.Errorf("failed to park dead-lettered webhook event %s: %v", j.eventID, err)
+			}
+		}
+
+		q.removeFromQueue(j.queueID)
+	}
+}
+
+// deliver sends a single signed POST request for one job.
+func (q *Queue) deliver(j job) error {
+	body, err := json.Marshal(j.envelope)
+	if err != nil {
+		return pkgError.WebhookError(fmt.Sprintf("failed to marshal envelope: %v", err))
+	}
+
+	req, err := http.NewRequest(http.MethodPost, j.url, bytes.NewReader(body))
+	if err != nil {
+		return pkgError.WebhookError(fmt.Sprintf("error when create http object %v", err))
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Event-ID", j.eventID)
+	req.Header.Set("X-Hub-Signature-256", sign(q.secret, body))
+
+	resp, err := q.client.Do(req)
+	if err != nil {
+		return pkgError.WebhookError(fmt.Sprintf("error when submit webhook %v", err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return pkgError.WebhookError(fmt.Sprintf("webhook %s responded with %d", j.url, resp.StatusCode))
+	}
+	return nil
+}
+
+// sign computes the X-Hub-Signature-256 header value for body.
+func sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// backoffWithJitter returns an exponential delay for the given attempt
+// number (1-indexed), capped at maxBackoff and jittered by up to 50% to
+// avoid every lane retrying in lockstep.
+func backoffWithJitter(attempt int) time.Duration {
+	delay := baseBackoff * time.Duration(1<<uint(attempt-1))
+	if delay > maxBackoff {
+		delay = maxBackoff
+	}
+	jitter := time.Duration(rand.Int63n(int64(delay) / 2))
+	return delay/2 + jitter
+}
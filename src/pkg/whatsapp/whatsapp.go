@@ -1,19 +1,18 @@
 package whatsapp
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
 	"github.com/aldinokemal/go-whatsapp-web-multidevice/config"
 	"github.com/aldinokemal/go-whatsapp-web-multidevice/internal/websocket"
 	pkgError "github.com/aldinokemal/go-whatsapp-web-multidevice/pkg/error"
+	"github.com/aldinokemal/go-whatsapp-web-multidevice/pkg/whatsapp/acl"
+	"github.com/aldinokemal/go-whatsapp-web-multidevice/pkg/whatsapp/webhook"
 	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
 	"go.mau.fi/whatsmeow"
 	"go.mau.fi/whatsmeow/appstate"
 	"go.mau.fi/whatsmeow/proto/waE2E"
-	"go.mau.fi/whatsmeow/store"
 	"go.mau.fi/whatsmeow/store/sqlstore"
 	"go.mau.fi/whatsmeow/types"
 	"go.mau.fi/whatsmeow/types/events"
@@ -24,17 +23,9 @@ import (
 	"os"
 	"regexp"
 	"strings"
-	"sync/atomic"
 	"time"
 )
 
-var (
-	cli           *whatsmeow.Client
-	log           waLog.Logger
-	historySyncID int32
-	startupTime   = time.Now().Unix()
-)
-
 type ExtractedMedia struct {
 	MediaPath string `json:"media_path"`
 	MimeType  string `json:"mime_type"`
@@ -137,42 +128,64 @@ func IsOnWhatsapp(waCli *whatsmeow.Client, jid string) bool {
 func ValidateJidWithLogin(waCli *whatsmeow.Client, jid string) (types.JID, error) {
 	MustLogin(waCli)
 
+	parsed, err := ParseJID(jid)
+	if err != nil {
+		return types.JID{}, err
+	}
+	if !acl.IsAllowed(parsed) {
+		return types.JID{}, pkgError.ErrJIDBlocked
+	}
+
 	if config.WhatsappAccountValidation && !IsOnWhatsapp(waCli, jid) {
 		return types.JID{}, pkgError.InvalidJID(fmt.Sprintf("Phone %s is not on whatsapp", jid))
 	}
 
-	return ParseJID(jid)
+	return parsed, nil
 }
 
+// InitWaDB opens the sqlstore container that backs every session, boots the
+// webhook, history, and ACL subsystems shared across sessions, then builds
+// the SessionManager on top of that container and reconnects every device
+// it already knows about. It returns the store container; the manager is
+// stashed in the package-level manager var, so sessionFromRequest and the REST handlers
+// registered by RegisterRoutes can reach it. Call RegisterRoutes once the
+// process has a *http.ServeMux to mount the REST API on.
 func InitWaDB() *sqlstore.Container {
-	// Running Whatsapp
-	log = waLog.Stdout("Main", config.WhatsappLogLevel, true)
 	dbLog := waLog.Stdout("Database", config.WhatsappLogLevel, true)
 	storeContainer, err := sqlstore.New("sqlite3", fmt.Sprintf("file:%s/%s?_foreign_keys=off", config.PathStorages, config.DBName), dbLog)
 	if err != nil {
-		log.Errorf("Failed to connect to database: %v", err)
 		panic(pkgError.InternalServerError(fmt.Sprintf("Failed to connect to database: %v", err)))
+	}
 
+	if err = webhook.Init(); err != nil {
+		panic(err)
 	}
-	return storeContainer
-}
 
-func InitWaCLI(storeContainer *sqlstore.Container) *whatsmeow.Client {
-	device, err := storeContainer.GetFirstDevice()
-	if err != nil {
-		log.Errorf("Failed to get device: %v", err)
+	if err = InitHistory(); err != nil {
 		panic(err)
 	}
 
-	osName := fmt.Sprintf("%s %s", config.AppOs, config.AppVersion)
-	store.DeviceProps.PlatformType = &config.AppPlatform
-	store.DeviceProps.Os = &osName
-	cli = whatsmeow.NewClient(device, waLog.Stdout("Client", config.WhatsappLogLevel, true))
-	cli.EnableAutoReconnect = true
-	cli.AutoTrustIdentity = true
-	cli.AddEventHandler(handler)
+	if err = acl.Init(); err != nil {
+		panic(err)
+	}
+
+	sessionManager := NewSessionManager(storeContainer)
+	if err = sessionManager.RestoreSessions(); err != nil {
+		panic(err)
+	}
+
+	return storeContainer
+}
 
-	return cli
+// RegisterRoutes wires the full WhatsApp REST API - sessions, contacts,
+// history, webhook admin, and ACL admin - onto mux. It must be called once
+// during startup, after InitWaDB.
+func RegisterRoutes(mux *http.ServeMux) {
+	manager.RegisterSessionRoutes(mux)
+	RegisterContactRoutes(mux)
+	RegisterHistoryRoutes(mux)
+	webhook.RegisterRoutes(mux)
+	acl.RegisterRoutes(mux)
 }
 
 func MustLogin(waCli *whatsmeow.Client) {
@@ -186,42 +199,42 @@ func MustLogin(waCli *whatsmeow.Client) {
 	}
 }
 
-func handler(rawEvt interface{}) {
+// handler is the whatsmeow event handler bound to this session's client. It
+// is a method so every event it reacts to can be routed through this
+// session's own client, logger, webhooks, and caches.
+func (s *Session) handler(rawEvt interface{}) {
 	switch evt := rawEvt.(type) {
 	case *events.DeleteForMe:
-		log.Infof("Deleted message %s for %s", evt.MessageID, evt.SenderJID.String())
+		s.Log.Infof("Deleted message %s for %s", evt.MessageID, evt.SenderJID.String())
 	case *events.AppStateSyncComplete:
-		if len(cli.Store.PushName) > 0 && evt.Name == appstate.WAPatchCriticalBlock {
-			err := cli.SendPresence(types.PresenceAvailable)
+		if len(s.Client.Store.PushName) > 0 && evt.Name == appstate.WAPatchCriticalBlock {
+			err := s.Client.SendPresence(types.PresenceAvailable)
 			if err != nil {
-				log.Warnf("Failed to send available presence: %v", err)
+				s.Log.Warnf("Failed to send available presence: %v", err)
 			} else {
-				log.Infof("Marked self as available")
+				s.Log.Infof("Marked self as available")
 			}
 		}
 	case *events.PairSuccess:
+		sessionID := manager.rekey(s.ID)
 		websocket.Broadcast <- websocket.BroadcastMessage{
 			Code:    "LOGIN_SUCCESS",
 			Message: fmt.Sprintf("Successfully pair with %s", evt.ID.String()),
+			Result:  map[string]interface{}{"session_id": sessionID},
 		}
 	case *events.LoggedOut:
 		websocket.Broadcast <- websocket.BroadcastMessage{
 			Code:   "LIST_DEVICES",
-			Result: nil,
-		}
-	case *events.Connected, *events.PushNameSetting:
-		if len(cli.Store.PushName) == 0 {
-			return
-		}
-
-		// Send presence available when connecting and when the pushname is changed.
-		// This makes sure that outgoing messages always have the right pushname.
-		err := cli.SendPresence(types.PresenceAvailable)
-		if err != nil {
-			log.Warnf("Failed to send available presence: %v", err)
-		} else {
-			log.Infof("Marked self as available")
+			Result: map[string]interface{}{"session_id": s.ID},
 		}
+	case *events.Connected:
+		s.refreshContacts()
+		s.markAvailable()
+	case *events.PushNameSetting:
+		s.refreshContacts()
+		s.markAvailable()
+	case *events.Contact:
+		s.refreshContact(evt.JID)
 	case *events.StreamReplaced:
 		os.Exit(0)
 	case *events.Message:
@@ -236,113 +249,159 @@ func handler(rawEvt interface{}) {
 			metaParts = append(metaParts, "view once")
 		}
 
-		log.Infof("Received message %s from %s (%s): %+v", evt.Info.ID, evt.Info.SourceString(), strings.Join(metaParts, ", "), evt.Message)
+		s.Log.Infof("Received message %s from %s (%s): %+v", evt.Info.ID, evt.Info.SourceString(), strings.Join(metaParts, ", "), evt.Message)
+
+		if !acl.IsAllowed(evt.Info.Chat) || !acl.IsAllowed(evt.Info.Sender) {
+			s.Log.Infof("Dropping message %s: %s is blocked by the ACL", evt.Info.ID, evt.Info.SourceString())
+			return
+		}
 
 		img := evt.Message.GetImageMessage()
 		if img != nil {
-			path, err := ExtractMedia(config.PathStorages, img)
+			path, err := ExtractMedia(s.Client, config.PathStorages, img)
 			if err != nil {
-				log.Errorf("Failed to download image: %v", err)
+				s.Log.Errorf("Failed to download image: %v", err)
 			} else {
-				log.Infof("Image downloaded to %s", path)
+				s.Log.Infof("Image downloaded to %s", path)
 			}
 		}
 
 		if config.WhatsappAutoReplyMessage != "" &&
 			!isGroupJid(evt.Info.Chat.String()) &&
-			!strings.Contains(evt.Info.SourceString(), "broadcast") {
-			_, _ = cli.SendMessage(context.Background(), evt.Info.Sender, &waE2E.Message{Conversation: proto.String(config.WhatsappAutoReplyMessage)})
+			!strings.Contains(evt.Info.SourceString(), "broadcast") &&
+			!acl.SuppressAutoReply(evt.Info.Sender) {
+			_, _ = s.Client.SendMessage(context.Background(), evt.Info.Sender, &waE2E.Message{Conversation: proto.String(config.WhatsappAutoReplyMessage)})
 		}
 
-		if config.WhatsappWebhook != "" &&
+		if len(config.WhatsappWebhook) > 0 &&
 			!strings.Contains(evt.Info.SourceString(), "broadcast") &&
-			!isFromMySelf(evt.Info.SourceString()) {
-			if err := forwardToWebhook(evt); err != nil {
-				logrus.Error("Failed forward to webhook", err)
-			}
+			!s.isFromMySelf(evt.Info.SourceString()) {
+			webhook.Enqueue(s.ID, "message", evt.Info.Chat.String(), evt.Info.Sender.String(), s.buildMessagePayload(evt))
 		}
 	case *events.Receipt:
 		if evt.Type == types.ReceiptTypeRead || evt.Type == types.ReceiptTypeReadSelf {
-			log.Infof("%v was read by %s at %s", evt.MessageIDs, evt.SourceString(), evt.Timestamp)
-			if config.WhatsappWebhook != "" && 
-				!isFromMySelf(evt.SourceString()) {
-				if err := forwardReceipt(evt); err != nil {
-					logrus.Error("Failed forward to webhook", err)
-				}
+			s.Log.Infof("%v was read by %s at %s", evt.MessageIDs, evt.SourceString(), evt.Timestamp)
+			if len(config.WhatsappWebhook) > 0 && !s.isFromMySelf(evt.SourceString()) {
+				webhook.Enqueue(s.ID, "receipt", evt.Chat.String(), evt.Sender.String(), buildReceiptPayload(evt))
 			}
 		} else if evt.Type == types.ReceiptTypeDelivered {
-			log.Infof("%s was delivered to %s at %s", evt.MessageIDs[0], evt.SourceString(), evt.Timestamp)
-			if config.WhatsappWebhook != "" && 
-				!isFromMySelf(evt.SourceString()) {
-				if err := forwardReceipt(evt); err != nil {
-					logrus.Error("Failed forward to webhook", err)
-				}	
+			s.Log.Infof("%s was delivered to %s at %s", evt.MessageIDs[0], evt.SourceString(), evt.Timestamp)
+			if len(config.WhatsappWebhook) > 0 && !s.isFromMySelf(evt.SourceString()) {
+				webhook.Enqueue(s.ID, "receipt", evt.Chat.String(), evt.Sender.String(), buildReceiptPayload(evt))
 			}
 		}
 	case *events.Presence:
 		if evt.Unavailable {
 			if evt.LastSeen.IsZero() {
-				log.Infof("%s is now offline", evt.From)
+				s.Log.Infof("%s is now offline", evt.From)
 			} else {
-				log.Infof("%s is now offline (last seen: %s)", evt.From, evt.LastSeen)
+				s.Log.Infof("%s is now offline (last seen: %s)", evt.From, evt.LastSeen)
 			}
 		} else {
-			log.Infof("%s is now online", evt.From)
+			s.Log.Infof("%s is now online", evt.From)
 		}
 	case *events.HistorySync:
-		id := atomic.AddInt32(&historySyncID, 1)
-		fileName := fmt.Sprintf("%s/history-%d-%s-%d-%s.json", config.PathStorages, startupTime, cli.Store.ID.String(), id, evt.Data.SyncType.String())
-		file, err := os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE, 0600)
-		if err != nil {
-			log.Errorf("Failed to open file to write history sync: %v", err)
-			return
-		}
-		enc := json.NewEncoder(file)
-		enc.SetIndent("", "  ")
-		err = enc.Encode(evt.Data)
-		if err != nil {
-			log.Errorf("Failed to write history sync: %v", err)
-			return
-		}
-		log.Infof("Wrote history sync to %s", fileName)
-		_ = file.Close()
+		s.ingestHistorySync(evt)
 	case *events.AppState:
-		log.Debugf("App state event: %+v / %+v", evt.Index, evt.SyncActionValue)
+		s.Log.Debugf("App state event: %+v / %+v", evt.Index, evt.SyncActionValue)
+	case *events.GroupInfo:
+		s.handleGroupInfo(evt)
+	case *events.JoinedGroup:
+		s.Log.Infof("Joined group %s, refreshing joined-groups cache", evt.JID.String())
+		s.refreshJoinedGroups()
 	}
 }
 
-// forwardReceipt is a helper function to forward receipt to webhook url
-func forwardReceipt(evt *events.Receipt) error {
-	logrus.Info("Forwarding receipt to webhook:", config.WhatsappWebhook)
-	client := &http.Client{Timeout: 10 * time.Second}
+// handleGroupInfo reacts to membership and metadata changes on a group:
+// joins/leaves, promotions/demotions, and subject/description updates. It
+// forwards a structured group_event to the webhooks and broadcasts a
+// GROUP_UPDATE over the websocket so connected frontends can refresh their
+// view of the group without polling.
+func (s *Session) handleGroupInfo(evt *events.GroupInfo) {
+	var action string
+	var affectedJids []string
+	switch {
+	case len(evt.Join) > 0:
+		action = "join"
+		affectedJids = jidsToStrings(evt.Join)
+	case len(evt.Leave) > 0:
+		action = "leave"
+		affectedJids = jidsToStrings(evt.Leave)
+	case len(evt.Promote) > 0:
+		action = "promote"
+		affectedJids = jidsToStrings(evt.Promote)
+	case len(evt.Demote) > 0:
+		action = "demote"
+		affectedJids = jidsToStrings(evt.Demote)
+	case evt.Topic != nil:
+		action = "topic_change"
+	case evt.Name != nil:
+		action = "name_change"
+	default:
+		action = "unknown"
+	}
 
-	body := map[string]interface{}{
-		"source":    evt.SourceString(),
-		"timestamp": evt.Timestamp,
-		"type":      evt.Type,
-		"ids":       evt.MessageIDs,
+	var newTopic string
+	if evt.Topic != nil {
+		newTopic = evt.Topic.Topic
 	}
 
-	postBody, err := json.Marshal(body)
-	if err != nil {
-		return pkgError.WebhookError(fmt.Sprintf("Failed to marshal body: %v", err))
+	var newName string
+	if evt.Name != nil {
+		newName = evt.Name.Name
 	}
 
-	req, err := http.NewRequest(http.MethodPost, config.WhatsappWebhook, bytes.NewBuffer(postBody))
-	if err != nil {
-		return pkgError.WebhookError(fmt.Sprintf("error when create http object %v", err))
+	s.Log.Infof("Group %s info event: action=%s actor=%s", evt.JID.String(), action, evt.Sender.String())
+
+	if len(config.WhatsappWebhook) > 0 {
+		webhook.Enqueue(s.ID, "group_event", evt.JID.String(), evt.Sender.String(), map[string]interface{}{
+			"group_jid":     evt.JID.String(),
+			"action":        action,
+			"affected_jids": affectedJids,
+			"actor_jid":     evt.Sender.String(),
+			"new_topic":     newTopic,
+			"new_name":      newName,
+		})
+	}
+
+	websocket.Broadcast <- websocket.BroadcastMessage{
+		Code: "GROUP_UPDATE",
+		Result: map[string]interface{}{
+			"session_id":    s.ID,
+			"group_jid":     evt.JID.String(),
+			"action":        action,
+			"affected_jids": affectedJids,
+			"actor_jid":     evt.Sender.String(),
+			"new_topic":     newTopic,
+			"new_name":      newName,
+		},
 	}
-	req.Header.Set("Content-Type", "application/json")
-	if _, err = client.Do(req); err != nil {
-		return pkgError.WebhookError(fmt.Sprintf("error when submit webhook %v", err))
+}
+
+// jidsToStrings is a helper function to render a slice of JIDs as strings
+// for webhook/websocket payloads.
+func jidsToStrings(jids []types.JID) []string {
+	out := make([]string, len(jids))
+	for i, jid := range jids {
+		out[i] = jid.String()
+	}
+	return out
+}
+
+// buildReceiptPayload is a helper function to build the webhook payload for
+// a read/delivered receipt.
+func buildReceiptPayload(evt *events.Receipt) map[string]interface{} {
+	return map[string]interface{}{
+		"source":    evt.SourceString(),
+		"timestamp": evt.Timestamp,
+		"type":      evt.Type,
+		"ids":       evt.MessageIDs,
 	}
-	return nil
 }
 
-// forwardToWebhook is a helper function to forward event to webhook url
-func forwardToWebhook(evt *events.Message) error {
-	logrus.Info("Forwarding event to webhook:", config.WhatsappWebhook)
-	client := &http.Client{Timeout: 10 * time.Second}
+// buildMessagePayload is a helper function to build the webhook payload for
+// an incoming message, downloading any attached media along the way.
+func (s *Session) buildMessagePayload(evt *events.Message) map[string]interface{} {
 	imageMedia := evt.Message.GetImageMessage()
 	stickerMedia := evt.Message.GetStickerMessage()
 	videoMedia := evt.Message.GetVideoMessage()
@@ -387,7 +446,7 @@ func forwardToWebhook(evt *events.Message) error {
 		"location":       evt.Message.GetLocationMessage(),
 		"message":        message,
 		"order":          evt.Message.GetOrderMessage(),
-		"pushname":       evt.Info.PushName,
+		"pushname":       s.ResolveContact(evt.Info.Sender),
 		"quoted_message": quotedmessage,
 		"reaction":       waReaction,
 		"sticker":        stickerMedia,
@@ -396,55 +455,47 @@ func forwardToWebhook(evt *events.Message) error {
 	}
 
 	if imageMedia != nil {
-		path, err := ExtractMedia(config.PathMedia, imageMedia)
+		path, err := ExtractMedia(s.Client, config.PathMedia, imageMedia)
 		if err != nil {
-			return pkgError.WebhookError(fmt.Sprintf("Failed to download image: %v", err))
+			logrus.Errorf("Failed to download image: %v", err)
+		} else {
+			body["image"] = path
 		}
-		body["image"] = path
 	}
 	if stickerMedia != nil {
-		path, err := ExtractMedia(config.PathMedia, stickerMedia)
+		path, err := ExtractMedia(s.Client, config.PathMedia, stickerMedia)
 		if err != nil {
-			return pkgError.WebhookError(fmt.Sprintf("Failed to download sticker: %v", err))
+			logrus.Errorf("Failed to download sticker: %v", err)
+		} else {
+			body["sticker"] = path
 		}
-		body["sticker"] = path
 	}
 	if videoMedia != nil {
-		path, err := ExtractMedia(config.PathMedia, videoMedia)
+		path, err := ExtractMedia(s.Client, config.PathMedia, videoMedia)
 		if err != nil {
-			return pkgError.WebhookError(fmt.Sprintf("Failed to download video: %v", err))
+			logrus.Errorf("Failed to download video: %v", err)
+		} else {
+			body["video"] = path
 		}
-		body["video"] = path
 	}
 	if audioMedia != nil {
-		path, err := ExtractMedia(config.PathMedia, audioMedia)
+		path, err := ExtractMedia(s.Client, config.PathMedia, audioMedia)
 		if err != nil {
-			return pkgError.WebhookError(fmt.Sprintf("Failed to download audio: %v", err))
+			logrus.Errorf("Failed to download audio: %v", err)
+		} else {
+			body["audio"] = path
 		}
-		body["audio"] = path
 	}
 	if documentMedia != nil {
-		path, err := ExtractMedia(config.PathMedia, documentMedia)
+		path, err := ExtractMedia(s.Client, config.PathMedia, documentMedia)
 		if err != nil {
-			return pkgError.WebhookError(fmt.Sprintf("Failed to download document: %v", err))
+			logrus.Errorf("Failed to download document: %v", err)
+		} else {
+			body["document"] = path
 		}
-		body["document"] = path
 	}
 
-	postBody, err := json.Marshal(body)
-	if err != nil {
-		return pkgError.WebhookError(fmt.Sprintf("Failed to marshal body: %v", err))
-	}
-
-	req, err := http.NewRequest(http.MethodPost, config.WhatsappWebhook, bytes.NewBuffer(postBody))
-	if err != nil {
-		return pkgError.WebhookError(fmt.Sprintf("error when create http object %v", err))
-	}
-	req.Header.Set("Content-Type", "application/json")
-	if _, err = client.Do(req); err != nil {
-		return pkgError.WebhookError(fmt.Sprintf("error when submit webhook %v", err))
-	}
-	return nil
+	return body
 }
 
 // isGroupJid is a helper function to check if the message is from group
@@ -452,9 +503,25 @@ func isGroupJid(jid string) bool {
 	return strings.Contains(jid, "@g.us")
 }
 
+// markAvailable sends presence available when connecting and when the
+// pushname is changed. This makes sure that outgoing messages always have
+// the right pushname.
+func (s *Session) markAvailable() {
+	if len(s.Client.Store.PushName) == 0 {
+		return
+	}
+
+	err := s.Client.SendPresence(types.PresenceAvailable)
+	if err != nil {
+		s.Log.Warnf("Failed to send available presence: %v", err)
+	} else {
+		s.Log.Infof("Marked self as available")
+	}
+}
+
 // isFromMySelf is a helper function to check if the message is from my self (logged in account)
-func isFromMySelf(jid string) bool {
-	return extractPhoneNumber(jid) == extractPhoneNumber(cli.Store.ID.String())
+func (s *Session) isFromMySelf(jid string) bool {
+	return extractPhoneNumber(jid) == extractPhoneNumber(s.Client.Store.ID.String())
 }
 
 // extractPhoneNumber is a helper function to extract the phone number from a JID
@@ -471,13 +538,13 @@ func extractPhoneNumber(jid string) string {
 }
 
 // ExtractMedia is a helper function to extract media from whatsapp
-func ExtractMedia(storageLocation string, mediaFile whatsmeow.DownloadableMessage) (extractedMedia ExtractedMedia, err error) {
+func ExtractMedia(waCli *whatsmeow.Client, storageLocation string, mediaFile whatsmeow.DownloadableMessage) (extractedMedia ExtractedMedia, err error) {
 	if mediaFile == nil {
 		logrus.Info("Skip download because data is nil")
 		return extractedMedia, nil
 	}
 
-	data, err := cli.Download(mediaFile)
+	data, err := waCli.Download(mediaFile)
 	if err != nil {
 		return extractedMedia, err
 	}